@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// 集群模式的相关 flag：未设置 -cluster-backend 时完全不启用集群行为，
+// 调度器按单机逻辑运行（向后兼容默认部署）。
+var (
+	clusterBackendFlag = flag.String("cluster-backend", "", "enable clustered mode: \"etcd\" or \"redis\"")
+	clusterEndpoints   = flag.String("cluster-endpoints", "localhost:2379", "comma-separated etcd endpoints or a single redis address")
+	clusterNodeID      = flag.String("cluster-node-id", "", "stable identifier for this node; defaults to hostname:pid")
+	clusterLeaseTTL    = 60 * time.Second
+)
+
+// clusterBackend 抽象了分布式协调存储，etcd 与 Redis 各自实现一套。
+type clusterBackend interface {
+	// tryAcquire 尝试为 key 在 ttl 内获得独占租约，成功返回 true。
+	tryAcquire(ctx context.Context, key string, ttl time.Duration, nodeID string) (bool, error)
+	// heartbeat 刷新本节点在成员目录中的存活状态。
+	heartbeat(ctx context.Context, nodeID string, ttl time.Duration) error
+	// members 列出当前存活的节点 ID。
+	members(ctx context.Context) ([]string, error)
+}
+
+// clusterManager 协调多实例下的任务唯一执行，并记录每条任务最近在哪些节点上运行过。
+type clusterManager struct {
+	backend clusterBackend
+	nodeID  string
+
+	mu          sync.Mutex
+	assignments map[string][]assignmentRecord // key: stable hash
+}
+
+// assignmentRecord 是 /api/crontab/{id}/assignments 返回的一条历史执行归属记录。
+type assignmentRecord struct {
+	NodeID string    `json:"nodeId"`
+	At     time.Time `json:"at"`
+}
+
+const maxAssignmentsPerJob = 20
+
+var cluster *clusterManager
+
+// nodePinRegexp 在一条任务的行内注释里匹配 "node=<id>" 置顶覆盖标记，
+// 例如整行注释是 "# node=workerA" 或 "# node=workerA keep warm"。
+var nodePinRegexp = regexp.MustCompile(`(?:^|\s)node=(\S+)`)
+
+// splitNodePin 从任务的行内注释（CrontabEntry.Comment，由 cronfile.go 的解析器
+// 从原始行尾部的 "# ..." 中拆出）里找出 "node=<id>" 固定标记。
+func splitNodePin(comment string) (remaining string, pinnedNode string) {
+	if m := nodePinRegexp.FindStringSubmatch(comment); m != nil {
+		return strings.TrimSpace(nodePinRegexp.ReplaceAllString(comment, "")), m[1]
+	}
+	return comment, ""
+}
+
+// initCluster 根据 flag 选择并初始化集群后端；未配置时返回 nil，调用方应视为未启用集群模式。
+func initCluster() (*clusterManager, error) {
+	if *clusterBackendFlag == "" {
+		return nil, nil
+	}
+
+	nodeID := *clusterNodeID
+	if nodeID == "" {
+		host, _ := os.Hostname()
+		nodeID = fmt.Sprintf("%s:%d", host, os.Getpid())
+	}
+
+	var backend clusterBackend
+	var err error
+	switch *clusterBackendFlag {
+	case "etcd":
+		backend, err = newEtcdBackend(strings.Split(*clusterEndpoints, ","))
+	case "redis":
+		backend = newRedisBackend(*clusterEndpoints)
+	default:
+		return nil, fmt.Errorf("unknown cluster backend %q", *clusterBackendFlag)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m := &clusterManager{backend: backend, nodeID: nodeID, assignments: make(map[string][]assignmentRecord)}
+	go m.heartbeatLoop()
+	return m, nil
+}
+
+func (m *clusterManager) heartbeatLoop() {
+	ticker := time.NewTicker(clusterLeaseTTL / 3)
+	defer ticker.Stop()
+	for {
+		if err := m.backend.heartbeat(context.Background(), m.nodeID, clusterLeaseTTL); err != nil {
+			log.Printf("cluster: heartbeat failed: %v", err)
+		}
+		<-ticker.C
+	}
+}
+
+// stableEntryHash 为一条任务的调度字段 + 命令生成稳定标识，
+// 与条目在 crontab 中的行号/解析顺序无关，用作租约 key。
+func stableEntryHash(entry CrontabEntry) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{
+		entry.Minute, entry.Hour, entry.DayOfMonth, entry.Month, entry.DayOfWeek, entry.Command,
+	}, "|")))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// shouldExecute 决定本节点是否应当执行给定任务在 fireTime 这个触发窗口内的这一次运行。
+// 如果条目被 "# node=<id>" 固定到某个节点，只有该节点执行；否则通过租约抢占实现单次执行。
+func (m *clusterManager) shouldExecute(entry CrontabEntry, fireTime time.Time) bool {
+	_, pinned := splitNodePin(entry.Comment)
+	if pinned != "" {
+		return pinned == m.nodeID
+	}
+
+	hash := stableEntryHash(entry)
+	window := fireTime.Truncate(time.Minute).Unix()
+	key := fmt.Sprintf("crontab-editor/lease/%s/%d", hash, window)
+
+	acquired, err := m.backend.tryAcquire(context.Background(), key, clusterLeaseTTL, m.nodeID)
+	if err != nil {
+		log.Printf("cluster: lease acquisition error for %s: %v", key, err)
+		return false
+	}
+	if acquired {
+		m.recordAssignment(hash, fireTime)
+	}
+	return acquired
+}
+
+func (m *clusterManager) recordAssignment(hash string, at time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	records := append(m.assignments[hash], assignmentRecord{NodeID: m.nodeID, At: at})
+	if len(records) > maxAssignmentsPerJob {
+		records = records[len(records)-maxAssignmentsPerJob:]
+	}
+	m.assignments[hash] = records
+}
+
+func (m *clusterManager) assignmentsFor(entry CrontabEntry) []assignmentRecord {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]assignmentRecord(nil), m.assignments[stableEntryHash(entry)]...)
+}
+
+// handleClusterMembersAPI 处理 GET /api/cluster/members。
+func handleClusterMembersAPI(w http.ResponseWriter, r *http.Request) {
+	if cluster == nil {
+		json.NewEncoder(w).Encode([]string{})
+		return
+	}
+	members, err := cluster.backend.members(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list members: %v", err), http.StatusInternalServerError)
+		return
+	}
+	sort.Strings(members)
+	json.NewEncoder(w).Encode(members)
+}
+
+// handleAssignmentsAPI 处理 GET /api/crontab/{id}/assignments，
+// 返回该任务最近 N 次执行的节点归属。id 此处沿用当前 currentCrontabEntries 中的序号。
+func handleAssignmentsAPI(w http.ResponseWriter, r *http.Request, entryID int) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	entries := getCurrentCrontabEntries()
+	var target *CrontabEntry
+	for i := range entries {
+		if entries[i].ID == entryID {
+			target = &entries[i]
+			break
+		}
+	}
+	if target == nil {
+		http.Error(w, "No such entry", http.StatusNotFound)
+		return
+	}
+	if cluster == nil {
+		json.NewEncoder(w).Encode([]assignmentRecord{})
+		return
+	}
+	json.NewEncoder(w).Encode(cluster.assignmentsFor(*target))
+}
+
+// --- etcd backend ---
+
+type etcdBackend struct {
+	client *clientv3.Client
+}
+
+func newEtcdBackend(endpoints []string) (*etcdBackend, error) {
+	client, err := clientv3.New(clientv3.Config{Endpoints: endpoints, DialTimeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("connect to etcd: %w", err)
+	}
+	return &etcdBackend{client: client}, nil
+}
+
+func (b *etcdBackend) tryAcquire(ctx context.Context, key string, ttl time.Duration, nodeID string) (bool, error) {
+	// 租约必须在 fire window 的整个 ttl 期间保持有效，所以这里直接 Grant 一个
+	// 到期自动失效的 lease 并绑定到 key 上，不在本函数返回时关闭/撤销它——
+	// 让 etcd 在 ttl 到期后自然回收，而不是像 concurrency.Session 那样
+	// 在 tryAcquire 返回时就 Close() 撤销租约，导致锁立刻被释放。
+	lease, err := b.client.Grant(ctx, int64(ttl.Seconds()))
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := b.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, nodeID, clientv3.WithLease(lease.ID))).
+		Commit()
+	if err != nil {
+		return false, err
+	}
+	if !resp.Succeeded {
+		// 没有抢到锁，这个 lease 没有被任何 key 引用，主动撤销以免白白占用。
+		b.client.Revoke(ctx, lease.ID)
+	}
+	return resp.Succeeded, nil
+}
+
+func (b *etcdBackend) heartbeat(ctx context.Context, nodeID string, ttl time.Duration) error {
+	lease, err := b.client.Grant(ctx, int64(ttl.Seconds()))
+	if err != nil {
+		return err
+	}
+	_, err = b.client.Put(ctx, "crontab-editor/workers/"+nodeID, strconv.FormatInt(time.Now().Unix(), 10), clientv3.WithLease(lease.ID))
+	return err
+}
+
+func (b *etcdBackend) members(ctx context.Context) ([]string, error) {
+	resp, err := b.client.Get(ctx, "crontab-editor/workers/", clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	members := make([]string, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		members = append(members, strings.TrimPrefix(string(kv.Key), "crontab-editor/workers/"))
+	}
+	return members, nil
+}
+
+// --- redis backend ---
+
+type redisBackend struct {
+	client *redis.Client
+}
+
+func newRedisBackend(addr string) *redisBackend {
+	return &redisBackend{client: redis.NewClient(&redis.Options{Addr: addr})}
+}
+
+func (b *redisBackend) tryAcquire(ctx context.Context, key string, ttl time.Duration, nodeID string) (bool, error) {
+	return b.client.SetNX(ctx, key, nodeID, ttl).Result()
+}
+
+func (b *redisBackend) heartbeat(ctx context.Context, nodeID string, ttl time.Duration) error {
+	return b.client.Set(ctx, "crontab-editor/workers/"+nodeID, time.Now().Unix(), ttl).Err()
+}
+
+func (b *redisBackend) members(ctx context.Context) ([]string, error) {
+	keys, err := b.client.Keys(ctx, "crontab-editor/workers/*").Result()
+	if err != nil {
+		return nil, err
+	}
+	members := make([]string, 0, len(keys))
+	for _, k := range keys {
+		members = append(members, strings.TrimPrefix(k, "crontab-editor/workers/"))
+	}
+	return members, nil
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// cronNodeKind 标识 CronFile 中一行的类型。
+type cronNodeKind int
+
+const (
+	nodeBlank cronNodeKind = iota
+	nodeComment
+	nodeEnv
+	nodeJob
+)
+
+// cronSpecialDescriptors 映射 "@yearly" 这类简写到标准五字段表达式，
+// "@reboot" 没有等价的字段表达式，单独处理。
+var cronSpecialDescriptors = map[string]struct{ minute, hour, dom, month, dow string }{
+	"@yearly":   {"0", "0", "1", "1", "*"},
+	"@annually": {"0", "0", "1", "1", "*"},
+	"@monthly":  {"0", "0", "1", "*", "*"},
+	"@weekly":   {"0", "0", "*", "*", "0"},
+	"@daily":    {"0", "0", "*", "*", "*"},
+	"@midnight": {"0", "0", "*", "*", "*"},
+	"@hourly":   {"0", "*", "*", "*", "*"},
+}
+
+// envLineRegexp 匹配 "NAME=VALUE" 形式的环境变量赋值行。
+var envLineRegexp = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)=(.*)$`)
+
+// jobFieldsRegexp 匹配标准五字段（或六字段，含可选的秒）加命令，
+// 命令之后允许有一个由空白分隔的尾随 "# ..." 注释。
+var jobFieldsRegexp = regexp.MustCompile(`^(\S+)\s+(\S+)\s+(\S+)\s+(\S+)\s+(\S+)(?:\s+(\S+))?\s+(.*)$`)
+
+// cronNode 是 CronFile 中的一行，保留足够的信息以便无损往返序列化。
+type cronNode struct {
+	kind cronNodeKind
+	raw  string // 原始行文本（对 blank/comment/env 节点，序列化时直接原样输出）
+
+	// job 节点专用字段
+	special       string // "@daily" 等描述符；非空时 minute..dayOfWeek 无意义
+	second        string // 仅六字段形式使用
+	minute        string
+	hour          string
+	dayOfMonth    string
+	month         string
+	dayOfWeek     string
+	command       string
+	inlineComment string // 命令后面的 "# ..." 部分，不含 "#"
+	enabled       bool   // false 表示这是被 "# " 注释掉的任务行
+
+	// env 节点专用字段
+	envName  string
+	envValue string
+}
+
+// cronFile 是对一份 crontab 文本的结构化表示，节点顺序与原文件一致。
+type cronFile struct {
+	nodes []*cronNode
+}
+
+// parseCronFile 把 crontab 原始文本解析成保留顺序与格式的节点列表。
+// 无法识别为任务行的注释被当作普通注释节点保留，而不是被丢弃。
+func parseCronFile(text string) *cronFile {
+	f := &cronFile{}
+	for _, line := range strings.Split(text, "\n") {
+		f.nodes = append(f.nodes, parseCronLine(line))
+	}
+	// strings.Split 在文本以换行结尾时会产生一个多余的空尾行，去掉它以避免
+	// 每次往返都在文件末尾多出一个空行。
+	if n := len(f.nodes); n > 0 && f.nodes[n-1].kind == nodeBlank && f.nodes[n-1].raw == "" && strings.HasSuffix(text, "\n") {
+		f.nodes = f.nodes[:n-1]
+	}
+	return f
+}
+
+func parseCronLine(line string) *cronNode {
+	trimmed := strings.TrimSpace(line)
+
+	if trimmed == "" {
+		return &cronNode{kind: nodeBlank, raw: line}
+	}
+
+	if strings.HasPrefix(trimmed, "#") {
+		// 被禁用的任务行形如 "# <job>"；尝试解析，失败则当作普通注释保留。
+		candidate := strings.TrimPrefix(trimmed, "#")
+		candidate = strings.TrimPrefix(candidate, " ")
+		if job := tryParseJobLine(candidate); job != nil {
+			job.enabled = false
+			job.raw = line
+			return job
+		}
+		return &cronNode{kind: nodeComment, raw: line}
+	}
+
+	if m := envLineRegexp.FindStringSubmatch(trimmed); m != nil {
+		return &cronNode{kind: nodeEnv, raw: line, envName: m[1], envValue: m[2]}
+	}
+
+	if job := tryParseJobLine(trimmed); job != nil {
+		job.enabled = true
+		job.raw = line
+		return job
+	}
+
+	// 无法识别的行（畸形任务行等）按注释/原样行保留，保证无损往返。
+	return &cronNode{kind: nodeComment, raw: line}
+}
+
+// tryParseJobLine 尝试把一行解析成任务节点，支持 "@daily" 风格描述符，
+// 标准五字段以及带秒的六字段形式。解析失败返回 nil。
+func tryParseJobLine(line string) *cronNode {
+	fields := strings.Fields(line)
+	if len(fields) >= 2 {
+		if spec, ok := cronSpecialDescriptors[fields[0]]; ok {
+			return &cronNode{
+				kind: nodeJob, special: fields[0],
+				minute: spec.minute, hour: spec.hour, dayOfMonth: spec.dom, month: spec.month, dayOfWeek: spec.dow,
+				command: strings.Join(fields[1:], " "),
+			}
+		}
+		if fields[0] == "@reboot" {
+			return &cronNode{kind: nodeJob, special: "@reboot", command: strings.Join(fields[1:], " ")}
+		}
+	}
+
+	m := jobFieldsRegexp.FindStringSubmatch(line)
+	if m == nil {
+		return nil
+	}
+
+	node := &cronNode{kind: nodeJob}
+	rest := m[7]
+	if m[6] != "" {
+		// 六个空白分隔字段被匹配：m[1..5] 是 分 时 日 月 周，m[6] 是秒，其余是命令。
+		// 但标准五字段 + 命令里命令第一个词也会落入 m[6]，所以只有当 m[6]
+		// 看起来像一个合法的秒字段（纯数字/通配/步进）时才当作秒处理。
+		if looksLikeCronField(m[6]) {
+			node.second = m[1]
+			node.minute = m[2]
+			node.hour = m[3]
+			node.dayOfMonth = m[4]
+			node.month = m[5]
+			node.dayOfWeek = m[6]
+			node.command = rest
+		} else {
+			node.minute = m[1]
+			node.hour = m[2]
+			node.dayOfMonth = m[3]
+			node.month = m[4]
+			node.dayOfWeek = m[5]
+			node.command = strings.TrimSpace(m[6] + " " + rest)
+		}
+	} else {
+		node.minute = m[1]
+		node.hour = m[2]
+		node.dayOfMonth = m[3]
+		node.month = m[4]
+		node.dayOfWeek = m[5]
+		node.command = rest
+	}
+
+	if idx := findInlineComment(node.command); idx != -1 {
+		node.inlineComment = strings.TrimSpace(strings.TrimPrefix(node.command[idx:], "#"))
+		node.command = strings.TrimSpace(node.command[:idx])
+	}
+	return node
+}
+
+// looksLikeCronField 粗略判断一个 token 是否像 crontab 的时间字段
+// （数字、*、范围、列表或步进的组合），用于区分六字段形式里的"秒"
+// 和五字段形式里命令的第一个单词。
+var cronFieldLikeRegexp = regexp.MustCompile(`^[0-9*][0-9*,/\-]*$`)
+
+func looksLikeCronField(token string) bool {
+	return cronFieldLikeRegexp.MatchString(token)
+}
+
+// findInlineComment 在命令文本中查找作为行内注释起点的 " #"，
+// 返回 "#" 在 command 中的索引，未找到返回 -1。
+func findInlineComment(command string) int {
+	idx := strings.Index(command, " #")
+	if idx == -1 {
+		return -1
+	}
+	return idx + 1
+}
+
+// jobScheduleFields 返回该节点的五个调度字段，供 parseCronSchedule 使用。
+func (n *cronNode) jobScheduleFields() (minute, hour, dom, month, dow string) {
+	return n.minute, n.hour, n.dayOfMonth, n.month, n.dayOfWeek
+}
+
+// render 把单个节点序列化回一行 crontab 文本。
+func (n *cronNode) render() string {
+	switch n.kind {
+	case nodeBlank, nodeComment, nodeEnv:
+		return n.raw
+	case nodeJob:
+		var line string
+		if n.special != "" {
+			line = n.special + " " + n.command
+		} else if n.second != "" {
+			// tryParseJobLine 把六字段形式的第一个字段解析成 second（秒 分 时 日 月 周），
+			// 序列化时必须保持同样的字段顺序，否则每次保存都会把 second 错位。
+			line = strings.Join([]string{n.second, n.minute, n.hour, n.dayOfMonth, n.month, n.dayOfWeek, n.command}, " ")
+		} else {
+			line = strings.Join([]string{n.minute, n.hour, n.dayOfMonth, n.month, n.dayOfWeek, n.command}, " ")
+		}
+		if n.inlineComment != "" {
+			line += " # " + n.inlineComment
+		}
+		if !n.enabled {
+			line = "# " + line
+		}
+		return line
+	}
+	return n.raw
+}
+
+// String 把整份 cronFile 序列化回文本，未改动的节点与原文件逐字节相同。
+func (f *cronFile) String() string {
+	lines := make([]string, len(f.nodes))
+	for i, n := range f.nodes {
+		lines[i] = n.render()
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// jobNodes 返回文件中所有任务节点（含已禁用的）及其在文件中的索引。
+func (f *cronFile) jobNodes() []int {
+	var idxs []int
+	for i, n := range f.nodes {
+		if n.kind == nodeJob {
+			idxs = append(idxs, i)
+		}
+	}
+	return idxs
+}
+
+// toCrontabEntry 把任务节点转换成对外的 CrontabEntry，id 由调用方指定
+// （按节点在文件中的位置编号，而不是每次解析都重新累加的全局计数器，
+// 这样同一份 crontab 在两次 GET 之间 id 保持稳定）。
+func (n *cronNode) toCrontabEntry(id int) CrontabEntry {
+	return CrontabEntry{
+		ID:         id,
+		Minute:     n.minute,
+		Hour:       n.hour,
+		DayOfMonth: n.dayOfMonth,
+		Month:      n.month,
+		DayOfWeek:  n.dayOfWeek,
+		Command:    n.command, // 保留末尾的 "# node=<id>" 固定标记（如果有），由 cluster.go 解析
+		RawLine:    n.render(),
+		Comment:    n.inlineComment,
+		Enabled:    n.enabled,
+		Managed:    isManagedEntry(n.inlineComment), // "managed" 标记，由 scheduler.go 解析
+		Special:    n.special,
+		Second:     n.second,
+	}
+}
+
+// applyEntry 用一个编辑后的 CrontabEntry 覆盖任务节点的可编辑字段，
+// 保留节点未被该 entry 涉及的部分。entry.Special/entry.Second 是
+// toCrontabEntry 原样带出来的展示字段，这里照抄回去而不是清空，
+// 否则每次保存都会把 "@daily" 描述符或六字段形式的秒吞掉。
+func (n *cronNode) applyEntry(entry CrontabEntry) {
+	n.special = entry.Special
+	n.second = entry.Second
+	if entry.Special == "" {
+		n.minute = entry.Minute
+		n.hour = entry.Hour
+		n.dayOfMonth = entry.DayOfMonth
+		n.month = entry.Month
+		n.dayOfWeek = entry.DayOfWeek
+	}
+	n.command = entry.Command
+	n.inlineComment = entry.Comment
+	n.enabled = entry.Enabled
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseCronFieldStepAndRange(t *testing.T) {
+	var minute [60]bool
+	if err := parseCronField("*/5", 0, 59, nil, minute[:]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for v := 0; v < 60; v++ {
+		want := v%5 == 0
+		if minute[v] != want {
+			t.Errorf("minute[%d] = %v, want %v", v, minute[v], want)
+		}
+	}
+
+	var dom [32]bool
+	if err := parseCronField("1-30/2", 1, 31, nil, dom[:]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for v := 1; v <= 31; v++ {
+		want := v <= 30 && (v-1)%2 == 0
+		if dom[v] != want {
+			t.Errorf("dom[%d] = %v, want %v", v, dom[v], want)
+		}
+	}
+}
+
+func TestParseDayOfWeekFieldSundayAlias(t *testing.T) {
+	var standalone [7]bool
+	if err := parseDayOfWeekField("7", dowNames, standalone[:]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for v := 0; v < 7; v++ {
+		want := v == 0
+		if standalone[v] != want {
+			t.Errorf("standalone[%d] = %v, want %v", v, standalone[v], want)
+		}
+	}
+
+	// "0-7" means every day of the week; it must not collapse to "only Sunday".
+	var everyDay [7]bool
+	if err := parseDayOfWeekField("0-7", dowNames, everyDay[:]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for v := 0; v < 7; v++ {
+		if !everyDay[v] {
+			t.Errorf("everyDay[%d] = false, want true", v)
+		}
+	}
+
+	// "5-7" means Fri-Sat-Sun; it must not be rejected as an invalid "5-0" range.
+	var friToSun [7]bool
+	if err := parseDayOfWeekField("5-7", dowNames, friToSun[:]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for v := 0; v < 7; v++ {
+		want := v == 0 || v == 5 || v == 6
+		if friToSun[v] != want {
+			t.Errorf("friToSun[%d] = %v, want %v", v, friToSun[v], want)
+		}
+	}
+}
+
+func TestParseCronFieldNameList(t *testing.T) {
+	var dow [7]bool
+	if err := parseCronField("mon-fri", 0, 6, dowNames, dow[:]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for v := 0; v < 7; v++ {
+		want := v >= 1 && v <= 5
+		if dow[v] != want {
+			t.Errorf("dow[%d] = %v, want %v", v, dow[v], want)
+		}
+	}
+}
+
+func TestParseCronLineInlineComment(t *testing.T) {
+	node := parseCronLine("*/15 * * * * /usr/bin/backup.sh # nightly backup")
+	if node.kind != nodeJob {
+		t.Fatalf("expected job node, got kind %v", node.kind)
+	}
+	if node.command != "/usr/bin/backup.sh" {
+		t.Errorf("command = %q, want %q", node.command, "/usr/bin/backup.sh")
+	}
+	if node.inlineComment != "nightly backup" {
+		t.Errorf("inlineComment = %q, want %q", node.inlineComment, "nightly backup")
+	}
+}
+
+func TestParseCronFileRoundTrip(t *testing.T) {
+	input := "MAILTO=ops@example.com\n" +
+		"# keep this comment\n" +
+		"*/5 * * * * /usr/bin/true\n" +
+		"\n" +
+		"0 9 * * mon-fri /usr/bin/report.sh\n"
+
+	file := parseCronFile(input)
+	if got := file.String(); got != input {
+		t.Fatalf("round trip mismatch:\ngot:  %q\nwant: %q", got, input)
+	}
+}
+
+func TestParseCronFileRoundTripWithSeconds(t *testing.T) {
+	input := "15 30 8 * * * /bin/job\n"
+
+	file := parseCronFile(input)
+	node := file.nodes[0]
+	if node.second != "15" || node.minute != "30" || node.hour != "8" {
+		t.Fatalf("unexpected parse: second=%q minute=%q hour=%q", node.second, node.minute, node.hour)
+	}
+	if got := file.String(); got != input {
+		t.Fatalf("round trip mismatch:\ngot:  %q\nwant: %q", got, input)
+	}
+}
+
+func TestApplyEntriesToggleDisablePreservesSurroundingContext(t *testing.T) {
+	input := "MAILTO=ops@example.com\n" +
+		"# keep this comment\n" +
+		"*/5 * * * * /usr/bin/true\n" +
+		"0 9 * * mon-fri /usr/bin/report.sh\n"
+
+	file := parseCronFile(input)
+	entries := []CrontabEntry{}
+	for _, idx := range file.jobNodes() {
+		entries = append(entries, file.nodes[idx].toCrontabEntry(idx))
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 job entries, got %d", len(entries))
+	}
+
+	// Disable the first job, leave the second untouched.
+	entries[0].Enabled = false
+
+	merged := applyEntriesToCronFile(parseCronFile(input), entries)
+	output := merged.String()
+
+	if !strings.Contains(output, "MAILTO=ops@example.com") {
+		t.Errorf("env assignment was not preserved:\n%s", output)
+	}
+	if !strings.Contains(output, "# keep this comment") {
+		t.Errorf("unrelated comment was not preserved:\n%s", output)
+	}
+	if !strings.Contains(output, "# */5 * * * * /usr/bin/true") {
+		t.Errorf("disabled job line not rendered as expected:\n%s", output)
+	}
+	if !strings.Contains(output, "0 9 * * mon-fri /usr/bin/report.sh") {
+		t.Errorf("untouched job line was not preserved:\n%s", output)
+	}
+}
+
+func TestApplyEntriesKeepsSubscriptionManagedJobs(t *testing.T) {
+	input := "0 9 * * * /usr/bin/report.sh\n" +
+		"# BEGIN sub:infra\n" +
+		"*/5 * * * * /usr/bin/sync.sh\n" +
+		"# END sub:infra\n"
+
+	file := parseCronFile(input)
+	entries := []CrontabEntry{}
+	for _, idx := range file.jobNodes() {
+		entries = append(entries, file.nodes[idx].toCrontabEntry(idx))
+	}
+
+	// Simulate a normal save that only knows about the non-subscription job,
+	// e.g. because the edit was staged before the last subscription sync ran.
+	var submitted []CrontabEntry
+	for _, e := range entries {
+		if e.Command == "/usr/bin/report.sh" {
+			submitted = append(submitted, e)
+		}
+	}
+
+	merged := applyEntriesToCronFile(parseCronFile(input), submitted)
+	output := merged.String()
+
+	if !strings.Contains(output, "/usr/bin/sync.sh") {
+		t.Errorf("subscription-managed job was deleted by an unrelated save:\n%s", output)
+	}
+	if !strings.Contains(output, "# BEGIN sub:infra") || !strings.Contains(output, "# END sub:infra") {
+		t.Errorf("subscription fence markers were not preserved:\n%s", output)
+	}
+}
+
+func TestApplyEntryPreservesSpecialDescriptor(t *testing.T) {
+	input := "@daily /usr/bin/backup.sh\n"
+
+	file := parseCronFile(input)
+	entries := []CrontabEntry{}
+	for _, idx := range file.jobNodes() {
+		entries = append(entries, file.nodes[idx].toCrontabEntry(idx))
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 job entry, got %d", len(entries))
+	}
+
+	// Saving back an untouched entry should not expand "@daily" into five fields.
+	merged := applyEntriesToCronFile(parseCronFile(input), entries)
+	if got := merged.String(); got != input {
+		t.Fatalf("descriptor was not preserved:\ngot:  %q\nwant: %q", got, input)
+	}
+}
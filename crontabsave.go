@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// historyLimit 控制 ~/.crontab-editor/history 下保留的快照数量，超出的旧快照会被清理。
+var historyLimit = flag.Int("history-limit", 50, "number of crontab history snapshots to keep")
+
+// fieldError 描述一条任务里某个字段未能通过校验的原因，随 422 响应返回给前端。
+type fieldError struct {
+	EntryIndex int    `json:"entryIndex"`
+	Field      string `json:"field"`
+	Reason     string `json:"reason"`
+}
+
+// validateEntries 用 cron 解析器逐条校验提交的任务，返回所有失败的字段；
+// 返回空切片表示全部合法。
+func validateEntries(entries []CrontabEntry) []fieldError {
+	var errs []fieldError
+	for i, entry := range entries {
+		check := func(field, value string, min, max int, names map[string]int) {
+			var scratch [64]bool
+			if err := parseCronField(value, min, max, names, scratch[:max+1]); err != nil {
+				errs = append(errs, fieldError{EntryIndex: i, Field: field, Reason: err.Error()})
+			}
+		}
+		// "@daily"/"@reboot" 这类描述符条目（entry.Special 非空）不经由
+		// Minute..DayOfWeek 五个字段调度，"@reboot" 的这五个字段本来就是空的，
+		// 用标准字段校验规则去查会把未改动的描述符条目也判成非法，跳过即可。
+		if entry.Special == "" {
+			check("minute", entry.Minute, 0, 59, nil)
+			check("hour", entry.Hour, 0, 23, nil)
+			check("dayOfMonth", entry.DayOfMonth, 1, 31, nil)
+			check("month", entry.Month, 1, 12, monthNames)
+			var dow [7]bool
+			if err := parseDayOfWeekField(entry.DayOfWeek, dowNames, dow[:]); err != nil {
+				errs = append(errs, fieldError{EntryIndex: i, Field: "dayOfWeek", Reason: err.Error()})
+			}
+		}
+		if strings.TrimSpace(entry.Command) == "" {
+			errs = append(errs, fieldError{EntryIndex: i, Field: "command", Reason: "command is required"})
+		}
+	}
+	return errs
+}
+
+// crontabLockPath 是写入 crontab 时持有的文件锁，防止两个浏览器标签页的并发编辑互相覆盖。
+func crontabLockPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("resolve home dir: %w", err)
+	}
+	dir := filepath.Join(home, ".crontab-editor")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "crontab.lock"), nil
+}
+
+// withCrontabLock 在持有一个独占文件锁的情况下执行 fn，
+// 确保同一时间只有一次 "crontab <file>" 调用在进行。
+func withCrontabLock(fn func() error) error {
+	path, err := crontabLockPath()
+	if err != nil {
+		return err
+	}
+	lockFile, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("open lock file: %w", err)
+	}
+	defer lockFile.Close()
+
+	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("acquire crontab lock: %w", err)
+	}
+	defer syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
+
+	return fn()
+}
+
+// historyDir 是 crontab 快照的存放目录。
+func historyDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("resolve home dir: %w", err)
+	}
+	return filepath.Join(home, ".crontab-editor", "history"), nil
+}
+
+// snapshotHistory 把当前 crontab 的完整内容存一份带时间戳的快照，
+// 并清理超出 historyLimit 的旧快照。
+func snapshotHistory(content string) error {
+	dir, err := historyDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	ts := time.Now().UTC().Format("20060102T150405Z")
+	if err := os.WriteFile(filepath.Join(dir, ts+".cron"), []byte(content), 0644); err != nil {
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+
+	names, err := historySnapshotNames()
+	if err != nil {
+		return err
+	}
+	if len(names) > *historyLimit {
+		for _, stale := range names[:len(names)-*historyLimit] {
+			os.Remove(filepath.Join(dir, stale+".cron"))
+		}
+	}
+	return nil
+}
+
+// historySnapshotNames 返回所有快照的时间戳（不含扩展名），按时间升序排列。
+func historySnapshotNames() ([]string, error) {
+	dir, err := historyDir()
+	if err != nil {
+		return nil, err
+	}
+	files, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, f := range files {
+		if strings.HasSuffix(f.Name(), ".cron") {
+			names = append(names, strings.TrimSuffix(f.Name(), ".cron"))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// handleHistoryListAPI 处理 GET /api/history，列出所有快照时间戳（最新的在前）。
+func handleHistoryListAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	names, err := historySnapshotNames()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list history: %v", err), http.StatusInternalServerError)
+		return
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+	json.NewEncoder(w).Encode(names)
+}
+
+// handleHistoryItemAPI 处理 GET /api/history/{ts} 和 POST /api/history/{ts}/restore。
+func handleHistoryItemAPI(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/history/")
+	parts := strings.Split(strings.Trim(rest, "/"), "/")
+	if len(parts) == 0 || parts[0] == "" {
+		http.Error(w, "Timestamp is required", http.StatusBadRequest)
+		return
+	}
+	ts := parts[0]
+	dir, err := historyDir()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	path := filepath.Join(dir, filepath.Base(ts)+".cron")
+
+	if len(parts) == 2 && parts[1] == "restore" {
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Snapshot not found: %v", err), http.StatusNotFound)
+			return
+		}
+		if err := withCrontabLock(func() error { return writeCrontabRaw(string(content)) }); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to restore snapshot: %v", err), http.StatusInternalServerError)
+			return
+		}
+		getCrontab(w, r)
+		return
+	}
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Snapshot not found: %v", err), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(content)
+}
+
+// unifiedDiff 生成 oldText 到 newText 的简单统一格式 diff（逐行 LCS），
+// 用于 dryRun 预览，不依赖外部 diff 工具。
+func unifiedDiff(oldText, newText string) string {
+	oldLines := strings.Split(strings.TrimRight(oldText, "\n"), "\n")
+	newLines := strings.Split(strings.TrimRight(newText, "\n"), "\n")
+
+	// dp[i][j] = oldLines[i:] 与 newLines[j:] 的最长公共子序列长度
+	dp := make([][]int, len(oldLines)+1)
+	for i := range dp {
+		dp[i] = make([]int, len(newLines)+1)
+	}
+	for i := len(oldLines) - 1; i >= 0; i-- {
+		for j := len(newLines) - 1; j >= 0; j-- {
+			if oldLines[i] == newLines[j] {
+				dp[i][j] = dp[i+1][j+1] + 1
+			} else if dp[i+1][j] >= dp[i][j+1] {
+				dp[i][j] = dp[i+1][j]
+			} else {
+				dp[i][j] = dp[i][j+1]
+			}
+		}
+	}
+
+	var out []string
+	i, j := 0, 0
+	for i < len(oldLines) && j < len(newLines) {
+		switch {
+		case oldLines[i] == newLines[j]:
+			out = append(out, "  "+oldLines[i])
+			i++
+			j++
+		case dp[i+1][j] >= dp[i][j+1]:
+			out = append(out, "- "+oldLines[i])
+			i++
+		default:
+			out = append(out, "+ "+newLines[j])
+			j++
+		}
+	}
+	for ; i < len(oldLines); i++ {
+		out = append(out, "- "+oldLines[i])
+	}
+	for ; j < len(newLines); j++ {
+		out = append(out, "+ "+newLines[j])
+	}
+	return strings.Join(out, "\n") + "\n"
+}
@@ -1,17 +1,17 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
 	"os"
 	"os/exec"
-	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time" // 导入 time 包以处理时间相关逻辑
 )
 
@@ -21,33 +21,93 @@ const (
 
 // CrontabEntry 结构体表示一个 crontab 任务
 type CrontabEntry struct {
-	ID      int    `json:"id"`
-	Minute  string `json:"minute"`
-	Hour    string `json:"hour"`
+	ID         int         `json:"id"`
+	Minute     string      `json:"minute"`
+	Hour       string      `json:"hour"`
+	DayOfMonth string      `json:"dayOfMonth"`
+	Month      string      `json:"month"`
+	DayOfWeek  string      `json:"dayOfWeek"`
+	Command    string      `json:"command"`
+	RawLine    string      `json:"rawLine"`           // 存储原始行，用于修改
+	Comment    string      `json:"comment"`           // 如果有注释，也一并存储
+	Enabled    bool        `json:"enabled"`           // 是否启用
+	Managed    bool        `json:"managed"`           // 是否由内置调度器执行，而非系统 crontab 守护进程
+	Special    string      `json:"special,omitempty"` // "@daily"/"@reboot" 等描述符；非空时 Minute..DayOfWeek 仅供展示
+	Second     string      `json:"second,omitempty"`  // 六字段形式（秒 分 时 日 月 周）里的秒字段
+	NextRun    []time.Time `json:"nextRun,omitempty"` // 接下来几次预计触发时间，服务端计算
+
+	LastRun        *time.Time `json:"lastRun,omitempty"`        // 最近一次运行的开始时间
+	LastStatus     string     `json:"lastStatus,omitempty"`     // "ok" 或 "failed"
+	LastDurationMs int64      `json:"lastDurationMs,omitempty"` // 最近一次运行耗时
+}
+
+// previewRequest 是 /api/crontab/preview 的请求体，只含表达式，不涉及用户的真实 crontab。
+type previewRequest struct {
+	Minute     string `json:"minute"`
+	Hour       string `json:"hour"`
 	DayOfMonth string `json:"dayOfMonth"`
-	Month   string `json:"month"`
-	DayOfWeek string `json:"dayOfWeek"`
-	Command string `json:"command"`
-	RawLine string `json:"rawLine"` // 存储原始行，用于修改
-	Comment string `json:"comment"` // 如果有注释，也一并存储
-	Enabled bool   `json:"enabled"` // 是否启用
+	Month      string `json:"month"`
+	DayOfWeek  string `json:"dayOfWeek"`
+}
+
+// currentCrontabEntries 缓存最近一次 GET /api/crontab 看到的条目，
+// 供 /assignments 这类只读 API 按 ID 关联使用。HTTP handler 之间本身就是
+// 并发的，所以读写都经过 currentCrontabEntriesMu，不直接碰裸的切片。
+var (
+	currentCrontabEntries   []CrontabEntry
+	currentCrontabEntriesMu sync.Mutex
+)
+
+// setCurrentCrontabEntries 加锁替换缓存的条目快照。
+func setCurrentCrontabEntries(entries []CrontabEntry) {
+	currentCrontabEntriesMu.Lock()
+	defer currentCrontabEntriesMu.Unlock()
+	currentCrontabEntries = entries
 }
 
-// Global variable to keep track of the last known crontab entries
-var currentCrontabEntries []CrontabEntry
-var nextEntryID int // Used to assign unique IDs to entries
+// getCurrentCrontabEntries 加锁返回缓存快照的一份拷贝，避免调用方遍历的
+// 过程中切片被另一个 goroutine 整体替换。
+func getCurrentCrontabEntries() []CrontabEntry {
+	currentCrontabEntriesMu.Lock()
+	defer currentCrontabEntriesMu.Unlock()
+	return append([]CrontabEntry(nil), currentCrontabEntries...)
+}
 
 func main() {
-	// 初始化 nextEntryID
-	nextEntryID = 1
+	flag.Parse()
 
 	// 注册路由
 	http.HandleFunc("/", serveIndex)
 	http.HandleFunc("/api/crontab", handleCrontabAPI)
+	http.HandleFunc("/api/crontab/preview", handlePreviewAPI)
+	http.HandleFunc("/api/crontab/", handleCrontabEntrySubRoutes)
+	http.HandleFunc("/api/subscriptions", handleSubscriptionsAPI)
+	http.HandleFunc("/api/subscriptions/", handleSubscriptionItemAPI)
+	http.HandleFunc("/api/history", handleHistoryListAPI)
+	http.HandleFunc("/api/history/", handleHistoryItemAPI)
 
 	// 提供静态文件
 	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
 
+	subscriptions.load()
+	go subscriptions.run()
+
+	if err := ensureWrapperScript(); err != nil {
+		log.Printf("Failed to write logging wrapper shim: %v", err)
+	}
+
+	if c, err := initCluster(); err != nil {
+		log.Fatalf("Failed to initialize cluster backend: %v", err)
+	} else {
+		cluster = c
+	}
+	http.HandleFunc("/api/cluster/members", handleClusterMembersAPI)
+
+	if *managedMode {
+		log.Printf("Managed mode enabled: entries marked managed will run in-process")
+		go scheduler.run()
+	}
+
 	log.Printf("Server listening on http://localhost%s", port)
 	log.Fatal(http.ListenAndServe(port, nil))
 }
@@ -93,224 +153,216 @@ func getCrontab(w http.ResponseWriter, r *http.Request) {
 	}
 
 	entries := parseCrontabOutput(string(output))
-	currentCrontabEntries = entries // 更新全局变量
+	for i := range entries {
+		entries[i].NextRun = computeNextRuns(entries[i], maxPreviewRuns)
+		if last, ok := runHistory.lastRun(entries[i].ID); ok {
+			startedAt := last.StartedAt
+			entries[i].LastRun = &startedAt
+			entries[i].LastDurationMs = last.DurationMs
+			if last.ExitCode == 0 {
+				entries[i].LastStatus = "ok"
+			} else {
+				entries[i].LastStatus = "failed"
+			}
+		}
+	}
+	setCurrentCrontabEntries(entries) // 更新全局缓存
 	json.NewEncoder(w).Encode(entries)
 }
 
-// parseCrontabOutput 解析 crontab -l 的输出
-func parseCrontabOutput(output string) []CrontabEntry {
-	var entries []CrontabEntry
-	scanner := bufio.NewScanner(strings.NewReader(output))
-	cronRegex := regexp.MustCompile(`^(\S+)\s+(\S+)\s+(\S+)\s+(\S+)\s+(\S+)\s+(.*)$`) // 匹配时间字段和命令
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.TrimSpace(line) == "" {
-			continue
-		}
+// handleCrontabEntrySubRoutes 分发 /api/crontab/{id}/runs、
+// /api/crontab/{id}/runs/{runID}/log 和 /api/crontab/{id}/logs/stream。
+func handleCrontabEntrySubRoutes(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/crontab/")
+	parts := strings.Split(strings.Trim(rest, "/"), "/")
+	if len(parts) < 2 {
+		http.NotFound(w, r)
+		return
+	}
 
-		// 忽略环境变量和非任务行
-		if strings.HasPrefix(line, "#") {
-			// 处理被注释掉的任务
-			if strings.HasPrefix(line, "# ") { // 可能是我们自己注释掉的任务
-				// 尝试解析被注释掉的任务行
-				if matches := cronRegex.FindStringSubmatch(line[2:]); len(matches) == 7 { // 跳过 "# "
-					entries = append(entries, CrontabEntry{
-						ID:      nextEntryID,
-						Minute:  matches[1],
-						Hour:    matches[2],
-						DayOfMonth: matches[3],
-						Month:   matches[4],
-						DayOfWeek: matches[5],
-						Command: strings.TrimSpace(matches[6]),
-						RawLine: line,
-						Comment: "", // No separate comment for disabled entries for simplicity
-						Enabled: false,
-					})
-					nextEntryID++
-					continue
-				}
-			}
-			// 其他类型的注释，直接跳过
-			continue
-		}
-		if strings.Contains(line, "=") && !strings.HasPrefix(line, "*") { // 可能是环境变量
-			continue
-		}
+	entryID, err := strconv.Atoi(parts[0])
+	if err != nil {
+		http.Error(w, "Invalid entry id", http.StatusBadRequest)
+		return
+	}
 
-		if matches := cronRegex.FindStringSubmatch(line); len(matches) == 7 {
-			entries = append(entries, CrontabEntry{
-				ID:      nextEntryID,
-				Minute:  matches[1],
-				Hour:    matches[2],
-				DayOfMonth: matches[3],
-				Month:   matches[4],
-				DayOfWeek: matches[5],
-				Command: strings.TrimSpace(matches[6]),
-				RawLine: line,
-				Comment: "",
-				Enabled: true,
-			})
-			nextEntryID++
-		} else {
-			// 如果不符合标准的 cron 格式，但也不是注释，就作为原始行保存
-			log.Printf("Warning: Non-standard crontab line skipped: %s", line)
-			// 或者你可以选择将其作为一个特殊条目来处理
+	switch {
+	case len(parts) == 2 && parts[1] == "runs":
+		handleRunsAPI(w, r, entryID)
+	case len(parts) == 4 && parts[1] == "runs" && parts[3] == "log":
+		runID, err := strconv.Atoi(parts[2])
+		if err != nil {
+			http.Error(w, "Invalid run id", http.StatusBadRequest)
+			return
 		}
+		handleRunLogAPI(w, r, entryID, runID)
+	case len(parts) == 3 && parts[1] == "logs" && parts[2] == "stream":
+		handleLogStreamAPI(w, r, entryID)
+	case len(parts) == 2 && parts[1] == "assignments":
+		handleAssignmentsAPI(w, r, entryID)
+	default:
+		http.NotFound(w, r)
 	}
-	return entries
 }
 
-// updateCrontab 更新 crontab 任务
-func updateCrontab(w http.ResponseWriter, r *http.Request) {
-	var updatedEntries []CrontabEntry
-	if err := json.NewDecoder(r.Body).Decode(&updatedEntries); err != nil {
+// handlePreviewAPI 根据请求中的表达式计算接下来的触发时间，不读取也不修改用户的 crontab。
+func handlePreviewAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req previewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, fmt.Sprintf("Failed to parse request body: %v", err), http.StatusBadRequest)
 		return
 	}
 
-	// 重新构建完整的 crontab 内容
-	var newCrontabContent bytes.Buffer
-	existingRawLines := make(map[string]bool) // 记录已处理的原始行，避免重复
-
-	// 优先添加所有 enabled 的新行
-	for _, entry := range updatedEntries {
-		if entry.Enabled {
-			newLine := fmt.Sprintf("%s %s %s %s %s %s",
-				entry.Minute, entry.Hour, entry.DayOfMonth, entry.Month, entry.DayOfWeek, entry.Command)
-			newCrontabContent.WriteString(newLine)
-			newCrontabContent.WriteString("\n")
-			existingRawLines[entry.RawLine] = true // 标记原始行已处理
-		} else {
-			// 对于 disabled 的条目，如果它之前是 enabled 状态，则把它注释掉
-			// 如果它已经是注释状态，则保持注释
-			if entry.RawLine != "" && !strings.HasPrefix(entry.RawLine, "#") {
-				// 说明之前是 enabled 的，现在被 disable 了
-				newCrontabContent.WriteString(fmt.Sprintf("# %s\n", entry.RawLine))
-				existingRawLines[entry.RawLine] = true
-			} else if entry.RawLine != "" && strings.HasPrefix(entry.RawLine, "#") {
-				// 之前就是 disabled 的
-				newCrontabContent.WriteString(fmt.Sprintf("%s\n", entry.RawLine))
-				existingRawLines[entry.RawLine] = true
-			} else {
-				// 新增的 disabled 任务，直接以注释形式添加
-				newLine := fmt.Sprintf("# %s %s %s %s %s %s",
-					entry.Minute, entry.Hour, entry.DayOfMonth, entry.Month, entry.DayOfWeek, entry.Command)
-				newCrontabContent.WriteString(newLine)
-				newCrontabContent.WriteString("\n")
-				existingRawLines[entry.RawLine] = true // 虽然是新的，但仍然标记一下
-			}
-		}
+	schedule, err := parseCronSchedule(req.Minute, req.Hour, req.DayOfMonth, req.Month, req.DayOfWeek)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid cron expression: %v", err), http.StatusBadRequest)
+		return
 	}
 
-	// 读取当前的 crontab 以保留非任务行 (如环境变量，其他注释)
+	json.NewEncoder(w).Encode(schedule.nextRuns(time.Now(), maxPreviewRuns))
+}
+
+// parseCrontabOutput 把 crontab -l 的输出解析成 CrontabEntry 列表。
+// 内部基于 cronFile 这个无损 AST：环境变量行、普通注释、空行都被保留在
+// AST 中（updateCrontab 依赖这一点做到保存时不丢失用户的原始格式），
+// 这里只是把其中的任务节点投影成外部 API 使用的 CrontabEntry。
+func parseCrontabOutput(output string) []CrontabEntry {
+	file := parseCronFile(output)
+	var entries []CrontabEntry
+	for _, idx := range file.jobNodes() {
+		entries = append(entries, file.nodes[idx].toCrontabEntry(idx))
+	}
+	return entries
+}
+
+// readCrontabRaw 读取当前用户的 crontab 原始文本，空 crontab 返回空字符串而非错误。
+func readCrontabRaw() (string, error) {
 	cmd := exec.Command("crontab", "-l")
 	output, err := cmd.Output()
 	if err != nil {
-		// 如果 crontab 为空，则不需要合并其他行
 		if exitErr, ok := err.(*exec.ExitError); ok && strings.Contains(string(exitErr.Stderr), "no crontab for") {
-			// 继续执行，因为没有要合并的旧行
-		} else {
-			log.Printf("Error executing crontab -l for merge: %v, Output: %s", err, string(output))
-			http.Error(w, fmt.Sprintf("Failed to list crontab for merge: %v", err), http.StatusInternalServerError)
-			return
+			return "", nil
 		}
+		return "", fmt.Errorf("failed to list crontab: %w", err)
 	}
+	return string(output), nil
+}
 
-	scanner := bufio.NewScanner(strings.NewReader(string(output)))
-	cronRegex := regexp.MustCompile(`^(\S+)\s+(\S+)\s+(\S+)\s+(\S+)\s+(\S+)\s+(.*)$`) // 用于判断是否为 crontab 任务行
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		trimmedLine := strings.TrimSpace(line)
-
-		// 如果是空行或注释，且不是我们管理的任务行，则保留
-		if trimmedLine == "" || strings.HasPrefix(trimmedLine, "#") {
-			// 但要确保它不是我们通过 disable 机制生成的注释行
-			// 否则如果用户在编辑器里把一个注释行修改成了普通行，可能导致重复
-			// 这里简单地检查一下原始行是否在我们的更新列表中，以避免重复添加
-			isManagedComment := false
-			for _, entry := range updatedEntries {
-				// Check if the current line is the rawLine of any updated entry
-				// This comparison might need to be more robust for real-world scenarios
-				if entry.RawLine == line {
-					isManagedComment = true
-					break
-				}
-				// Also check if it's a commented version of an updated entry's command
-				if !entry.Enabled && strings.HasPrefix(line, "# ") {
-					testLine := line[2:]
-					if cronRegex.MatchString(testLine) {
-						// This is a bit tricky, needs careful logic
-						// For now, assume if it matches an updated entry's rawLine, it's handled.
-					}
-				}
-			}
+// writeCrontabRaw 把给定内容整体写入用户的 crontab。
+func writeCrontabRaw(content string) error {
+	tmpfile, err := os.CreateTemp("", "crontab-editor-")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmpfile.Name())
 
-			if !isManagedComment && !cronRegex.MatchString(line) && !strings.HasPrefix(line, "# ") {
-				// 如果不是标准的 cron 任务行，也不是我们生成的注释行，就保留
-				// 还需要检查它是否已经被包含在 updatedEntries 中
-				found := false
-				for _, entry := range updatedEntries {
-					if entry.RawLine == line {
-						found = true
-						break
-					}
-				}
-				if !found {
-					newCrontabContent.WriteString(line)
-					newCrontabContent.WriteString("\n")
-				}
-			}
-			continue
-		}
+	if _, err := tmpfile.WriteString(content); err != nil {
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+	tmpfile.Close()
 
-		// 如果是旧的 cron 任务行，检查它是否已在 updatedEntries 中被处理
-		foundInUpdated := false
-		for _, entry := range updatedEntries {
-			// 精确匹配原始行
-			if entry.RawLine == line {
-				foundInUpdated = true
-				break
-			}
-			// 也要考虑原始行是注释，但现在变成启用状态的情况
-			if strings.HasPrefix(line, "# ") {
-				if entry.Enabled && fmt.Sprintf("%s %s %s %s %s %s", entry.Minute, entry.Hour, entry.DayOfMonth, entry.Month, entry.DayOfWeek, entry.Command) == line[2:] {
-					foundInUpdated = true
-					break
-				}
-			}
-		}
+	cmd := exec.Command("crontab", tmpfile.Name())
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to update crontab: %v, output: %s", err, output)
+	}
+	return nil
+}
 
-		// 如果是一个旧的非任务行（如环境变量），并且没有被更新的条目取代，则保留
-		if !cronRegex.MatchString(line) && !foundInUpdated {
-			newCrontabContent.WriteString(line)
-			newCrontabContent.WriteString("\n")
-		}
+// updateCrontab 更新 crontab 任务。
+//
+// 做法是把当前 crontab 解析成 cronFile，按 ID（即任务节点在文件中的位置）
+// 把提交上来的 entries 对应到已有任务节点并原地更新，没有被对应上的旧任务
+// 节点视为被删除，updatedEntries 里没有对应旧节点的视为新增。环境变量、
+// 普通注释、空行等非任务节点完全不受影响，从而在保存时保留用户原有的格式。
+func updateCrontab(w http.ResponseWriter, r *http.Request) {
+	var updatedEntries []CrontabEntry
+	if err := json.NewDecoder(r.Body).Decode(&updatedEntries); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse request body: %v", err), http.StatusBadRequest)
+		return
 	}
 
-	// 写入临时文件
-	tmpfile, err := os.CreateTemp("", "crontab-editor-")
+	if errs := validateEntries(updatedEntries); len(errs) > 0 {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(errs)
+		return
+	}
+
+	raw, err := readCrontabRaw()
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to create temp file: %v", err), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer os.Remove(tmpfile.Name()) // 确保临时文件被删除
 
-	if _, err := tmpfile.WriteString(newCrontabContent.String()); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to write to temp file: %v", err), http.StatusInternalServerError)
+	file := applyEntriesToCronFile(parseCronFile(raw), updatedEntries)
+	proposed := file.String()
+
+	if r.URL.Query().Get("dryRun") == "1" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(unifiedDiff(raw, proposed)))
 		return
 	}
-	tmpfile.Close()
 
-	// 使用 crontab 命令更新
-	cmd = exec.Command("crontab", tmpfile.Name())
-	if output, err := cmd.CombinedOutput(); err != nil {
-		log.Printf("Error updating crontab: %v, Output: %s, Crontab Content:\n%s", err, string(output), newCrontabContent.String())
-		http.Error(w, fmt.Sprintf("Failed to update crontab: %v, Output: %s", err, string(output)), http.StatusInternalServerError)
+	if err := snapshotHistory(raw); err != nil {
+		log.Printf("Failed to snapshot crontab history: %v", err)
+	}
+
+	if err := withCrontabLock(func() error { return writeCrontabRaw(proposed) }); err != nil {
+		log.Printf("Error updating crontab: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	// 成功后重新获取并返回最新的 crontab
 	getCrontab(w, r) // 调用 GET 方法来获取最新的 crontab，并返回给前端
 }
+
+// applyEntriesToCronFile 把编辑后的条目列表合并进已解析的 cronFile，
+// 返回合并后的新 cronFile。
+func applyEntriesToCronFile(file *cronFile, updatedEntries []CrontabEntry) *cronFile {
+	existingJobIdx := file.jobNodes()
+	matchedNodeIdx := make(map[int]bool)
+	usedEntryIdx := make(map[int]bool)
+
+	for _, idx := range existingJobIdx {
+		for ei, entry := range updatedEntries {
+			if usedEntryIdx[ei] || entry.ID != idx {
+				continue
+			}
+			file.nodes[idx].applyEntry(entry)
+			matchedNodeIdx[idx] = true
+			usedEntryIdx[ei] = true
+			break
+		}
+	}
+
+	existingSet := make(map[int]bool, len(existingJobIdx))
+	for _, idx := range existingJobIdx {
+		existingSet[idx] = true
+	}
+	subManaged := subscriptionManagedJobIndices(file)
+
+	kept := make([]*cronNode, 0, len(file.nodes))
+	for i, n := range file.nodes {
+		if n.kind == nodeJob && existingSet[i] && !matchedNodeIdx[i] && !subManaged[i] {
+			continue // 该任务未出现在提交的列表中，视为被用户删除
+		}
+		kept = append(kept, n)
+	}
+	file.nodes = kept
+
+	for ei, entry := range updatedEntries {
+		if usedEntryIdx[ei] {
+			continue
+		}
+		node := &cronNode{kind: nodeJob}
+		node.applyEntry(entry)
+		file.nodes = append(file.nodes, node)
+	}
+
+	return file
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxRunsPerEntry 是每个条目在磁盘上保留的运行记录上限，超出的旧记录会被清理。
+const maxRunsPerEntry = 100
+
+// RunRecord 描述一次任务执行的结果。
+type RunRecord struct {
+	RunID      int       `json:"runId"`
+	StartedAt  time.Time `json:"startedAt"`
+	EndedAt    time.Time `json:"endedAt"`
+	ExitCode   int       `json:"exitCode"`
+	DurationMs int64     `json:"durationMs"`
+}
+
+// runHistoryStore 在磁盘上为每个 crontab 条目维护一个滚动的运行记录目录：
+// ~/.crontab-editor/runs/<entryID>/index.json 保存记录列表，
+// ~/.crontab-editor/runs/<entryID>/<runID>.log 保存该次运行的完整输出。
+type runHistoryStore struct {
+	mu           sync.Mutex
+	broadcasters map[int]*logBroadcaster
+}
+
+var runHistory = &runHistoryStore{broadcasters: make(map[int]*logBroadcaster)}
+
+func runHistoryBaseDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("resolve home dir: %w", err)
+	}
+	return filepath.Join(home, ".crontab-editor", "runs"), nil
+}
+
+func entryDir(entryID int) (string, error) {
+	base, err := runHistoryBaseDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(base, strconv.Itoa(entryID)), nil
+}
+
+func (s *runHistoryStore) index(entryID int) ([]RunRecord, error) {
+	dir, err := entryDir(entryID)
+	if err != nil {
+		return nil, err
+	}
+	var records []RunRecord
+	data, err := os.ReadFile(filepath.Join(dir, "index.json"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &records); err != nil {
+			return nil, err
+		}
+	}
+
+	wrapperRecords, err := readWrapperIndex(dir)
+	if err != nil {
+		return nil, err
+	}
+	records = mergeWrapperRecords(records, wrapperRecords)
+
+	sort.Slice(records, func(i, j int) bool { return records[i].RunID < records[j].RunID })
+	return records, nil
+}
+
+// wrapperRunRecord 对应 wrap.sh 追加到 wrapper_index.jsonl 的一行：
+// 时间戳是 "date +%s%3N" 产出的毫秒级 Unix 时间戳字符串，而不是
+// RunRecord.StartedAt/EndedAt 使用的 RFC3339，所以单独定义一个类型解析，
+// 再转换成 RunRecord 供 /runs 接口统一返回。
+type wrapperRunRecord struct {
+	RunID      int    `json:"runId"`
+	StartedAt  string `json:"startedAt"`
+	EndedAt    string `json:"endedAt"`
+	ExitCode   int    `json:"exitCode"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+// readWrapperIndex 读取 wrap.sh 写入的 wrapper_index.jsonl（逐行一个 JSON 对象），
+// 并把每条记录转换成 RunRecord。文件不存在（未使用过 wrap.sh）视为没有记录。
+func readWrapperIndex(dir string) ([]RunRecord, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "wrapper_index.jsonl"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var records []RunRecord
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		var wr wrapperRunRecord
+		if err := json.Unmarshal([]byte(line), &wr); err != nil {
+			log.Printf("Skipping malformed wrapper_index.jsonl line: %v", err)
+			continue
+		}
+		startedAtMs, err := strconv.ParseInt(wr.StartedAt, 10, 64)
+		if err != nil {
+			log.Printf("Skipping wrapper_index.jsonl line with invalid startedAt: %v", err)
+			continue
+		}
+		endedAtMs, err := strconv.ParseInt(wr.EndedAt, 10, 64)
+		if err != nil {
+			log.Printf("Skipping wrapper_index.jsonl line with invalid endedAt: %v", err)
+			continue
+		}
+		records = append(records, RunRecord{
+			RunID:      wr.RunID,
+			StartedAt:  time.UnixMilli(startedAtMs),
+			EndedAt:    time.UnixMilli(endedAtMs),
+			ExitCode:   wr.ExitCode,
+			DurationMs: wr.DurationMs,
+		})
+	}
+	return records, nil
+}
+
+// mergeWrapperRecords 把 wrap.sh 产生的记录并入内置调度器写入的记录，
+// 按 RunID 去重——两套计数器各自独立递增，同一个 entry 正常只会用其中一套
+// （要么交给 managed 调度器执行，要么手动接上 wrap.sh），出现 RunID 冲突时
+// 以 index.json 里 managed 调度器写入的记录为准。
+func mergeWrapperRecords(records, wrapperRecords []RunRecord) []RunRecord {
+	seen := make(map[int]bool, len(records))
+	for _, r := range records {
+		seen[r.RunID] = true
+	}
+	for _, r := range wrapperRecords {
+		if seen[r.RunID] {
+			continue
+		}
+		records = append(records, r)
+		seen[r.RunID] = true
+	}
+	return records
+}
+
+func (s *runHistoryStore) saveIndex(entryID int, records []RunRecord) error {
+	dir, err := entryDir(entryID)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "index.json"), data, 0644)
+}
+
+// begin 为一次即将开始的运行分配运行 ID 和一个日志写入器；写入器把内容
+// 同时落盘并广播给该条目上任何正在 tail 的 SSE 客户端。
+func (s *runHistoryStore) begin(entryID int) (runID int, logWriter io.Writer, finish func(exitCode int, startedAt time.Time), err error) {
+	s.mu.Lock()
+	records, loadErr := s.index(entryID)
+	s.mu.Unlock()
+	if loadErr != nil {
+		return 0, nil, nil, loadErr
+	}
+
+	runID = 1
+	if len(records) > 0 {
+		runID = records[len(records)-1].RunID + 1
+	}
+
+	dir, derr := entryDir(entryID)
+	if derr != nil {
+		return 0, nil, nil, derr
+	}
+	if merr := os.MkdirAll(dir, 0755); merr != nil {
+		return 0, nil, nil, merr
+	}
+	logFile, ferr := os.Create(filepath.Join(dir, fmt.Sprintf("%d.log", runID)))
+	if ferr != nil {
+		return 0, nil, nil, ferr
+	}
+
+	bc := s.broadcaster(entryID)
+	writer := io.MultiWriter(logFile, bc)
+
+	finish = func(exitCode int, startedAt time.Time) {
+		logFile.Close()
+		bc.close()
+		now := time.Now()
+		record := RunRecord{
+			RunID:      runID,
+			StartedAt:  startedAt,
+			EndedAt:    now,
+			ExitCode:   exitCode,
+			DurationMs: now.Sub(startedAt).Milliseconds(),
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		records, _ := s.index(entryID)
+		records = append(records, record)
+		if len(records) > maxRunsPerEntry {
+			stale := records[:len(records)-maxRunsPerEntry]
+			records = records[len(records)-maxRunsPerEntry:]
+			for _, r := range stale {
+				os.Remove(filepath.Join(dir, fmt.Sprintf("%d.log", r.RunID)))
+			}
+		}
+		if err := s.saveIndex(entryID, records); err != nil {
+			log.Printf("Failed to persist run history for entry %d: %v", entryID, err)
+		}
+	}
+	return runID, writer, finish, nil
+}
+
+func (s *runHistoryStore) broadcaster(entryID int) *logBroadcaster {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bc, ok := s.broadcasters[entryID]
+	if !ok {
+		bc = newLogBroadcaster()
+		s.broadcasters[entryID] = bc
+	}
+	return bc
+}
+
+// lastRun 返回某条目最近一次的运行记录，用于在列表里标注 LastRun/LastStatus/LastDurationMs。
+func (s *runHistoryStore) lastRun(entryID int) (RunRecord, bool) {
+	records, err := s.index(entryID)
+	if err != nil || len(records) == 0 {
+		return RunRecord{}, false
+	}
+	return records[len(records)-1], true
+}
+
+// logBroadcaster 把写入的字节同时发送给所有当前订阅的 SSE 客户端。
+type logBroadcaster struct {
+	mu   sync.Mutex
+	subs map[chan []byte]bool
+}
+
+func newLogBroadcaster() *logBroadcaster {
+	return &logBroadcaster{subs: make(map[chan []byte]bool)}
+}
+
+func (b *logBroadcaster) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	cp := append([]byte(nil), p...)
+	for ch := range b.subs {
+		select {
+		case ch <- cp:
+		default:
+			// 订阅者处理太慢，丢弃这次推送而不是阻塞执行中的任务
+		}
+	}
+	return len(p), nil
+}
+
+func (b *logBroadcaster) subscribe() chan []byte {
+	ch := make(chan []byte, 16)
+	b.mu.Lock()
+	b.subs[ch] = true
+	b.mu.Unlock()
+	return ch
+}
+
+// unsubscribe 移除一个订阅者。如果 close() 已经把这个 channel 关闭并从
+// b.subs 里摘掉（运行结束的正常路径），这里就不再重复 close，避免
+// "close of closed channel" panic。
+func (b *logBroadcaster) unsubscribe(ch chan []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[ch]; !ok {
+		return
+	}
+	delete(b.subs, ch)
+	close(ch)
+}
+
+func (b *logBroadcaster) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		close(ch)
+		delete(b.subs, ch)
+	}
+}
+
+// handleRunsAPI 处理 GET /api/crontab/{id}/runs，返回分页的运行记录。
+func handleRunsAPI(w http.ResponseWriter, r *http.Request, entryID int) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	records, err := runHistory.index(entryID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read run history: %v", err), http.StatusInternalServerError)
+		return
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].RunID > records[j].RunID })
+
+	page := 1
+	pageSize := 20
+	if v, err := strconv.Atoi(r.URL.Query().Get("page")); err == nil && v > 0 {
+		page = v
+	}
+	if v, err := strconv.Atoi(r.URL.Query().Get("pageSize")); err == nil && v > 0 {
+		pageSize = v
+	}
+	start := (page - 1) * pageSize
+	if start > len(records) {
+		start = len(records)
+	}
+	end := start + pageSize
+	if end > len(records) {
+		end = len(records)
+	}
+	json.NewEncoder(w).Encode(records[start:end])
+}
+
+// handleRunLogAPI 处理 GET /api/crontab/{id}/runs/{runID}/log，返回完整日志内容。
+func handleRunLogAPI(w http.ResponseWriter, r *http.Request, entryID, runID int) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	dir, err := entryDir(entryID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("%d.log", runID)))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Log not found: %v", err), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(data)
+}
+
+// handleLogStreamAPI 处理 GET /api/crontab/{id}/logs/stream，通过 SSE
+// 实时推送当前正在执行的运行的输出。
+func handleLogStreamAPI(w http.ResponseWriter, r *http.Request, entryID int) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	bc := runHistory.broadcaster(entryID)
+	ch := bc.subscribe()
+	defer bc.unsubscribe(ch)
+
+	for {
+		select {
+		case chunk, open := <-ch:
+			if !open {
+				fmt.Fprintf(w, "event: done\ndata: {}\n\n")
+				flusher.Flush()
+				return
+			}
+			for _, line := range strings.Split(strings.TrimRight(string(chunk), "\n"), "\n") {
+				fmt.Fprintf(w, "data: %s\n", line)
+			}
+			fmt.Fprint(w, "\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// wrapperScriptContents 是写入 ~/.crontab-editor/wrap.sh 的 shim 脚本。
+// 未启用托管模式时，用户可以把某个 crontab 命令手动改成
+// "~/.crontab-editor/wrap.sh <entryID> <原命令>"，这样该次运行的输出、
+// 退出码和耗时同样会落在 run history 目录下，供 /runs 接口读取。
+const wrapperScriptContents = `#!/bin/sh
+# Auto-generated by crontab-editor. Do not edit by hand.
+ENTRY_ID="$1"
+shift
+RUN_DIR="$HOME/.crontab-editor/runs/$ENTRY_ID"
+mkdir -p "$RUN_DIR"
+NEXT_ID=1
+if [ -f "$RUN_DIR/.next_id" ]; then
+  NEXT_ID=$(cat "$RUN_DIR/.next_id")
+fi
+echo $((NEXT_ID + 1)) > "$RUN_DIR/.next_id"
+LOG_FILE="$RUN_DIR/$NEXT_ID.log"
+START=$(date +%s%3N)
+"$@" > "$LOG_FILE" 2>&1
+CODE=$?
+END=$(date +%s%3N)
+echo "{\"runId\":$NEXT_ID,\"startedAt\":\"$START\",\"endedAt\":\"$END\",\"exitCode\":$CODE,\"durationMs\":$((END - START))}" >> "$RUN_DIR/wrapper_index.jsonl"
+exit $CODE
+`
+
+// ensureWrapperScript 把 shim 脚本写到 ~/.crontab-editor/wrap.sh 并加上可执行权限，
+// 供用户在不启用托管模式时手动接入运行记录。
+func ensureWrapperScript() error {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return fmt.Errorf("resolve home dir: %w", err)
+	}
+	dir := filepath.Join(home, ".crontab-editor")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, "wrap.sh")
+	return os.WriteFile(path, []byte(wrapperScriptContents), 0755)
+}
+
+// runHistoryReader 打开某次运行的完整日志文件，供其他工具（如导出或转发）复用。
+func runHistoryReader(entryID, runID int) (io.ReadCloser, error) {
+	dir, err := entryDir(entryID)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(filepath.Join(dir, fmt.Sprintf("%d.log", runID)))
+}
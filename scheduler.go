@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// managedMode 控制是否启用内置调度器，在此模式下任务由本进程直接执行，
+// 而不是依赖系统的 cron 守护进程。
+var managedMode = flag.Bool("managed", false, "run managed entries in-process instead of relying on the system crontab daemon")
+
+// managedMarkerRegexp 在任务的行内注释里匹配独立的 "managed" 标记，用来把
+// "这条任务由内置调度器执行" 这个状态存进 crontab 本身而不需要额外的存储，
+// 与 cluster.go 里 "node=<id>" 固定标记是同一个思路。系统的 cron 守护进程
+// 不理解这个标记，所以被标成 managed 的任务仍需要用户自行把它在真实
+// crontab 里注释掉（Enabled=false），避免两边重复执行。
+var managedMarkerRegexp = regexp.MustCompile(`(?:^|\s)managed(?:\s|$)`)
+
+// isManagedEntry 判断一个任务的行内注释里是否带有 "managed" 标记。
+func isManagedEntry(comment string) bool {
+	return managedMarkerRegexp.MatchString(comment)
+}
+
+// schedulerHorizon 是计算下一次运行时间时向未来搜索的最大时长，
+// 避免对无法匹配的表达式（如 2 月 30 日）做无限循环。
+const schedulerHorizon = 366 * 24 * time.Hour
+
+// maxPreviewRuns 是预览接口返回的最大触发时间数量。
+const maxPreviewRuns = 5
+
+var dowNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+var monthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+// cronSchedule 是对一条 crontab 五个时间字段解析后的结果，
+// 每个字段用布尔位图表示该字段允许的取值。
+type cronSchedule struct {
+	minute        [60]bool
+	hour          [24]bool
+	dayOfMonth    [32]bool // 1-31
+	month         [13]bool // 1-12
+	dayOfWeek     [7]bool  // 0-6, 0 = Sunday
+	domRestricted bool
+	dowRestricted bool
+}
+
+// parseCronSchedule 解析标准的五字段 crontab 表达式（不含秒）。
+func parseCronSchedule(minute, hour, dom, month, dow string) (*cronSchedule, error) {
+	s := &cronSchedule{}
+
+	if err := parseCronField(minute, 0, 59, nil, s.minute[:]); err != nil {
+		return nil, fmt.Errorf("minute field: %w", err)
+	}
+	if err := parseCronField(hour, 0, 23, nil, s.hour[:]); err != nil {
+		return nil, fmt.Errorf("hour field: %w", err)
+	}
+	if err := parseCronField(dom, 1, 31, nil, s.dayOfMonth[:]); err != nil {
+		return nil, fmt.Errorf("day-of-month field: %w", err)
+	}
+	if err := parseCronField(month, 1, 12, monthNames, s.month[:]); err != nil {
+		return nil, fmt.Errorf("month field: %w", err)
+	}
+	if err := parseDayOfWeekField(dow, dowNames, s.dayOfWeek[:]); err != nil {
+		return nil, fmt.Errorf("day-of-week field: %w", err)
+	}
+
+	s.domRestricted = strings.TrimSpace(dom) != "*" && strings.TrimSpace(dom) != "?"
+	s.dowRestricted = strings.TrimSpace(dow) != "*" && strings.TrimSpace(dow) != "?"
+	return s, nil
+}
+
+// parseCronField 把单个 crontab 字段（如 "*/5"、"1-30/2"、"mon-fri"）
+// 展开为 bitmask 写入 out，out 的长度应为 max+1。
+func parseCronField(field string, min, max int, names map[string]int, out []bool) error {
+	field = strings.TrimSpace(strings.ToLower(field))
+	if field == "" {
+		return fmt.Errorf("empty field")
+	}
+
+	for _, part := range strings.Split(field, ",") {
+		rangeStr := part
+		step := 1
+		if idx := strings.Index(part, "/"); idx != -1 {
+			rangeStr = part[:idx]
+			n, err := strconv.Atoi(part[idx+1:])
+			if err != nil || n <= 0 {
+				return fmt.Errorf("invalid step in %q", part)
+			}
+			step = n
+		}
+
+		lo, hi := min, max
+		switch {
+		case rangeStr == "*" || rangeStr == "?":
+			// lo/hi already cover the full range
+		case strings.Contains(rangeStr, "-"):
+			bounds := strings.SplitN(rangeStr, "-", 2)
+			a, err := resolveCronValue(bounds[0], names)
+			if err != nil {
+				return err
+			}
+			b, err := resolveCronValue(bounds[1], names)
+			if err != nil {
+				return err
+			}
+			lo, hi = a, b
+		default:
+			v, err := resolveCronValue(rangeStr, names)
+			if err != nil {
+				return err
+			}
+			lo, hi = v, v
+			step = 1
+		}
+
+		if lo < min || hi > max || lo > hi {
+			return fmt.Errorf("value out of range in %q (allowed %d-%d)", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			out[v] = true
+		}
+	}
+	return nil
+}
+
+// parseDayOfWeekField 和 parseCronField 类似，但额外接受 7 作为"周日"的别名
+// （crontab(5) 里 0 和 7 都表示周日）。不能简单地把字段文本里的 7 替换成 0
+// 再丢给 parseCronField——那样会把 "0-7"（每天）错误地变成 "0-0"（只有周日），
+// 把 "5-7"（周五到周日）变成不合法的 "5-0"。这里改为按 0-7 八个值整体解析，
+// 解析完再把下标 7 的位或（OR）回下标 0，这样范围/步进的语义完全不受影响，
+// out 仍然只需要 7 个槽位（下标 0-6）。
+func parseDayOfWeekField(field string, names map[string]int, out []bool) error {
+	var scratch [8]bool
+	if err := parseCronField(field, 0, 7, names, scratch[:]); err != nil {
+		return err
+	}
+	for v := 0; v < 7; v++ {
+		out[v] = scratch[v]
+	}
+	out[0] = out[0] || scratch[7]
+	return nil
+}
+
+// resolveCronValue 把字段中的单个 token（数字或名称，如 "mon"、"jan"）解析成整数。
+func resolveCronValue(token string, names map[string]int) (int, error) {
+	if names != nil {
+		if v, ok := names[token]; ok {
+			return v, nil
+		}
+	}
+	v, err := strconv.Atoi(token)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", token)
+	}
+	return v, nil
+}
+
+// matches 判断给定时间（精确到分钟）是否命中该调度表达式，
+// 遵循 cron 标准中"日期与星期同时受限时取并集"的规则。
+func (s *cronSchedule) matches(t time.Time) bool {
+	if !s.minute[t.Minute()] || !s.hour[t.Hour()] || !s.month[int(t.Month())] {
+		return false
+	}
+	domMatch := s.dayOfMonth[t.Day()]
+	dowMatch := s.dayOfWeek[int(t.Weekday())]
+
+	switch {
+	case s.domRestricted && s.dowRestricted:
+		return domMatch || dowMatch
+	default:
+		return domMatch && dowMatch
+	}
+}
+
+// nextRuns 从 from 开始逐分钟向前搜索，返回最多 count 个满足调度表达式的时间点。
+func (s *cronSchedule) nextRuns(from time.Time, count int) []time.Time {
+	var out []time.Time
+	t := from.Truncate(time.Minute).Add(time.Minute)
+	deadline := from.Add(schedulerHorizon)
+	for t.Before(deadline) && len(out) < count {
+		if s.matches(t) {
+			out = append(out, t)
+		}
+		t = t.Add(time.Minute)
+	}
+	return out
+}
+
+// managedScheduler 在内存中维护已注册的托管任务，并按分钟粒度触发执行。
+type managedScheduler struct {
+	mu      sync.Mutex
+	cancels map[int]context.CancelFunc
+}
+
+var scheduler = &managedScheduler{cancels: make(map[int]context.CancelFunc)}
+
+// run 每分钟检查一次全局的 currentCrontabEntries，执行到期且启用的托管任务。
+// 仅在以 -managed 启动时由 main 调用。
+func (ms *managedScheduler) run() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		ms.tick(now)
+	}
+}
+
+func (ms *managedScheduler) tick(now time.Time) {
+	// 直接从 "crontab -l" 读取，而不是依赖 currentCrontabEntries 这个只在
+	// GET /api/crontab 时才会填充的缓存——否则无 UI 访问的纯后台 -managed
+	// 部署里这个全局变量永远是空的，调度器永远不会触发任何任务；同时也避免
+	// 和 HTTP handler 对同一个切片的并发读写。
+	raw, err := readCrontabRaw()
+	if err != nil {
+		log.Printf("managed scheduler: failed to read crontab: %v", err)
+		return
+	}
+	for _, entry := range parseCrontabOutput(raw) {
+		if !entry.Enabled || !entry.Managed {
+			continue // 未标记 managed 的任务交给系统 crontab 守护进程执行，调度器不重复触发
+		}
+		schedule, err := parseCronSchedule(entry.Minute, entry.Hour, entry.DayOfMonth, entry.Month, entry.DayOfWeek)
+		if err != nil {
+			continue
+		}
+		if !schedule.matches(now) {
+			continue
+		}
+		if cluster != nil && !cluster.shouldExecute(entry, now) {
+			continue // 另一个节点已经抢到了这个触发窗口的租约，或该任务被固定到其他节点
+		}
+		ms.execute(entry)
+	}
+}
+
+// execute 在一个可取消的 context 下异步运行任务命令。
+func (ms *managedScheduler) execute(entry CrontabEntry) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ms.mu.Lock()
+	if prev, ok := ms.cancels[entry.ID]; ok {
+		prev() // 任务仍与上一次重叠触发，取消上一次
+	}
+	ms.cancels[entry.ID] = cancel
+	ms.mu.Unlock()
+
+	go func() {
+		defer cancel()
+		startedAt := time.Now()
+		_, logWriter, finish, err := runHistory.begin(entry.ID)
+		if err != nil {
+			log.Printf("managed run for entry %d: failed to open run log: %v", entry.ID, err)
+			logWriter = io.Discard
+			finish = func(int, time.Time) {}
+		}
+
+		cmd := exec.CommandContext(ctx, "sh", "-c", entry.Command)
+		cmd.Stdout = logWriter
+		cmd.Stderr = logWriter
+		runErr := cmd.Run()
+
+		exitCode := 0
+		if runErr != nil {
+			exitCode = 1
+			if exitErr, ok := runErr.(*exec.ExitError); ok {
+				exitCode = exitErr.ExitCode()
+			}
+			log.Printf("managed run failed for entry %d (%s): %v", entry.ID, entry.Command, runErr)
+		}
+		finish(exitCode, startedAt)
+	}()
+}
+
+// computeNextRuns 为一个 CrontabEntry 计算接下来若干次触发时间，解析失败时返回 nil。
+func computeNextRuns(entry CrontabEntry, count int) []time.Time {
+	schedule, err := parseCronSchedule(entry.Minute, entry.Hour, entry.DayOfMonth, entry.Month, entry.DayOfWeek)
+	if err != nil {
+		return nil
+	}
+	return schedule.nextRuns(time.Now(), count)
+}
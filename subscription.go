@@ -0,0 +1,436 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// subscriptionStateFile 是订阅元数据持久化的位置，与可执行文件放在一起，
+// 这样多次重启后订阅列表不会丢失。
+const subscriptionStateFile = "subscriptions.json"
+
+// subscriptionCacheDir 是各订阅仓库本地克隆的根目录。
+const subscriptionCacheDir = ".crontab-editor/subscriptions"
+
+// Subscription 描述一个作为 crontab 条目来源的远程 Git 仓库。
+type Subscription struct {
+	Alias       string    `json:"alias"`
+	URL         string    `json:"url"`
+	Branch      string    `json:"branch"`
+	RefreshCron string    `json:"refreshCron"` // 刷新周期，标准五字段 crontab 表达式
+	Glob        string    `json:"glob"`        // 匹配 *.cron 文件的 glob，如 "*.cron"
+	LastSyncAt  time.Time `json:"lastSyncAt,omitempty"`
+	LastStatus  string    `json:"lastStatus,omitempty"` // "ok" 或 "error"
+	LastLog     string    `json:"lastLog,omitempty"`    // 最近一次同步的简要日志
+}
+
+// subscriptionManager 在内存中持有所有订阅，并负责持久化与定时刷新。
+type subscriptionManager struct {
+	mu   sync.Mutex
+	subs map[string]*Subscription
+}
+
+var subscriptions = &subscriptionManager{subs: make(map[string]*Subscription)}
+
+func (m *subscriptionManager) statePath() string {
+	return subscriptionStateFile
+}
+
+// load 从磁盘恢复订阅列表，文件不存在时视为空列表。
+func (m *subscriptionManager) load() {
+	data, err := os.ReadFile(m.statePath())
+	if err != nil {
+		return
+	}
+	var list []*Subscription
+	if err := json.Unmarshal(data, &list); err != nil {
+		log.Printf("Failed to parse %s: %v", m.statePath(), err)
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, s := range list {
+		m.subs[s.Alias] = s
+	}
+}
+
+// save 把当前订阅列表写回磁盘。调用方需已持有 m.mu。
+func (m *subscriptionManager) saveLocked() error {
+	list := make([]*Subscription, 0, len(m.subs))
+	for _, s := range m.subs {
+		list = append(list, s)
+	}
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(m.statePath(), data, 0644)
+}
+
+// run 每分钟检查一次各订阅的 RefreshCron 表达式，到期则触发同步。
+func (m *subscriptionManager) run() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		m.mu.Lock()
+		aliases := make([]string, 0, len(m.subs))
+		for alias, s := range m.subs {
+			schedule, err := parseCronSchedule(s.Minute(), s.Hour(), s.DayOfMonthField(), s.MonthField(), s.DayOfWeekField())
+			if err != nil || !schedule.matches(now) {
+				continue
+			}
+			aliases = append(aliases, alias)
+		}
+		m.mu.Unlock()
+
+		for _, alias := range aliases {
+			if err := m.sync(alias); err != nil {
+				log.Printf("subscription %s: scheduled sync failed: %v", alias, err)
+			}
+		}
+	}
+}
+
+// Minute/Hour/... 把 RefreshCron（标准五字段表达式）拆成各个字段，
+// 以便复用 scheduler.go 中的解析器。
+func (s *Subscription) fields() []string {
+	return strings.Fields(s.RefreshCron)
+}
+func (s *Subscription) Minute() string {
+	if f := s.fields(); len(f) == 5 {
+		return f[0]
+	}
+	return "*"
+}
+func (s *Subscription) Hour() string {
+	if f := s.fields(); len(f) == 5 {
+		return f[1]
+	}
+	return "*"
+}
+func (s *Subscription) DayOfMonthField() string {
+	if f := s.fields(); len(f) == 5 {
+		return f[2]
+	}
+	return "*"
+}
+func (s *Subscription) MonthField() string {
+	if f := s.fields(); len(f) == 5 {
+		return f[3]
+	}
+	return "*"
+}
+func (s *Subscription) DayOfWeekField() string {
+	if f := s.fields(); len(f) == 5 {
+		return f[4]
+	}
+	return "*"
+}
+
+// fenceBegin/fenceEnd 是某个订阅在 crontab 文件中对应片段的围栏标记，
+// updateCrontab 依据这对标记识别并跳过由订阅管理的行，避免重复或误删。
+func fenceBegin(alias string) string { return fmt.Sprintf("# BEGIN sub:%s", alias) }
+func fenceEnd(alias string) string   { return fmt.Sprintf("# END sub:%s", alias) }
+
+// subFenceBeginRegexp/subFenceEndRegexp 识别任意订阅的围栏标记（不限定 alias），
+// 用于在 applyEntriesToCronFile 里定位由订阅同步管理的任务行。
+var subFenceBeginRegexp = regexp.MustCompile(`^# BEGIN sub:(\S+)$`)
+var subFenceEndRegexp = regexp.MustCompile(`^# END sub:(\S+)$`)
+
+// subscriptionManagedJobIndices 返回 file 中落在某个订阅围栏片段内的任务节点下标。
+// 这些行由 mergeSubscriptionBlock 写入和维护，不应该被 applyEntriesToCronFile
+// 的"提交列表里没有就视为用户删除"逻辑删除——否则普通保存（尤其是在一次
+// 订阅同步之后，用户手头的编辑列表还不包含新同步进来的行）会把订阅管理的
+// 任务行当成被手动删掉。
+func subscriptionManagedJobIndices(file *cronFile) map[int]bool {
+	managed := make(map[int]bool)
+	inBlock := false
+	for i, n := range file.nodes {
+		trimmed := strings.TrimSpace(n.raw)
+		switch {
+		case subFenceBeginRegexp.MatchString(trimmed):
+			inBlock = true
+		case subFenceEndRegexp.MatchString(trimmed):
+			inBlock = false
+		case inBlock && n.kind == nodeJob:
+			managed[i] = true
+		}
+	}
+	return managed
+}
+
+// sync 拉取（或克隆）订阅仓库，解析其中的 *.cron 文件，并把结果合并进当前用户的 crontab。
+func (m *subscriptionManager) sync(alias string) error {
+	m.mu.Lock()
+	sub, ok := m.subs[alias]
+	m.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("no such subscription: %s", alias)
+	}
+
+	entries, err := fetchSubscriptionEntries(sub)
+	status := "ok"
+	logMsg := fmt.Sprintf("synced %d entries", len(entries))
+	if err != nil {
+		status = "error"
+		logMsg = err.Error()
+	} else if mergeErr := mergeSubscriptionBlock(alias, entries); mergeErr != nil {
+		status = "error"
+		logMsg = mergeErr.Error()
+	}
+
+	m.mu.Lock()
+	sub.LastSyncAt = time.Now()
+	sub.LastStatus = status
+	sub.LastLog = logMsg
+	saveErr := m.saveLocked()
+	m.mu.Unlock()
+	if saveErr != nil {
+		log.Printf("subscription %s: failed to persist state: %v", alias, saveErr)
+	}
+	if status == "error" {
+		return fmt.Errorf(logMsg)
+	}
+	return nil
+}
+
+// fetchSubscriptionEntries 克隆/拉取仓库并解析匹配 glob 的 *.cron 文件。
+func fetchSubscriptionEntries(sub *Subscription) ([]string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("resolve home dir: %w", err)
+	}
+	repoDir := filepath.Join(home, subscriptionCacheDir, sub.Alias)
+
+	repo, err := git.PlainOpen(repoDir)
+	if err != nil {
+		repo, err = git.PlainClone(repoDir, false, &git.CloneOptions{
+			URL:           sub.URL,
+			ReferenceName: plumbing.NewBranchReferenceName(sub.Branch),
+			SingleBranch:  true,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("clone %s: %w", sub.URL, err)
+		}
+	} else {
+		wt, wtErr := repo.Worktree()
+		if wtErr != nil {
+			return nil, fmt.Errorf("open worktree: %w", wtErr)
+		}
+		pullErr := wt.Pull(&git.PullOptions{
+			ReferenceName: plumbing.NewBranchReferenceName(sub.Branch),
+			SingleBranch:  true,
+		})
+		if pullErr != nil && pullErr != git.NoErrAlreadyUpToDate {
+			return nil, fmt.Errorf("pull %s: %w", sub.URL, pullErr)
+		}
+	}
+
+	pattern := sub.Glob
+	if pattern == "" {
+		pattern = "*.cron"
+	}
+	matches, err := filepath.Glob(filepath.Join(repoDir, pattern))
+	if err != nil {
+		return nil, fmt.Errorf("invalid glob %q: %w", pattern, err)
+	}
+
+	var lines []string
+	for _, path := range matches {
+		data, readErr := os.ReadFile(path)
+		if readErr != nil {
+			return nil, fmt.Errorf("read %s: %w", path, readErr)
+		}
+		for _, entry := range parseCrontabOutput(string(data)) {
+			if !entry.Enabled {
+				continue
+			}
+			lines = append(lines, fmt.Sprintf("%s %s %s %s %s %s",
+				entry.Minute, entry.Hour, entry.DayOfMonth, entry.Month, entry.DayOfWeek, entry.Command))
+		}
+	}
+	_ = repo // 仅用于触发克隆/拉取，这里不需要再读取 repo 对象
+	return lines, nil
+}
+
+// mergeSubscriptionBlock 把某个订阅的任务行写入当前 crontab 中由
+// "# BEGIN sub:<alias>" / "# END sub:<alias>" 包裹的片段，替换已有的同名片段
+// （如果存在），不触碰其余内容。
+func mergeSubscriptionBlock(alias string, lines []string) error {
+	current, err := readCrontabRaw()
+	if err != nil {
+		return err
+	}
+
+	begin, end := fenceBegin(alias), fenceEnd(alias)
+	var out []string
+	inBlock := false
+	replaced := false
+	for _, line := range strings.Split(current, "\n") {
+		switch {
+		case strings.TrimSpace(line) == begin:
+			inBlock = true
+			replaced = true
+			out = append(out, begin)
+			out = append(out, lines...)
+			out = append(out, end)
+		case strings.TrimSpace(line) == end:
+			inBlock = false
+		case inBlock:
+			// 跳过旧片段内容，已被上面新写入的内容取代
+		default:
+			out = append(out, line)
+		}
+	}
+	if !replaced {
+		if len(out) > 0 && strings.TrimSpace(out[len(out)-1]) != "" {
+			out = append(out, "")
+		}
+		out = append(out, begin)
+		out = append(out, lines...)
+		out = append(out, end)
+	}
+
+	return withCrontabLock(func() error { return writeCrontabRaw(strings.Join(out, "\n") + "\n") })
+}
+
+// removeSubscriptionBlock 删除某个订阅在 crontab 中对应的 "# BEGIN sub:<alias>" /
+// "# END sub:<alias>" 围栏片段（连同其中的任务行），在取消订阅时调用，
+// 避免订阅同步写入的任务行永久留在 crontab 里——subscriptionManagedJobIndices
+// 只要还能看到这对围栏标记，就会一直保护里面的行不被普通保存删除。
+// crontab 里本来就没有这个片段时视为无事发生。
+func removeSubscriptionBlock(alias string) error {
+	current, err := readCrontabRaw()
+	if err != nil {
+		return err
+	}
+
+	begin, end := fenceBegin(alias), fenceEnd(alias)
+	var out []string
+	inBlock := false
+	removed := false
+	for _, line := range strings.Split(current, "\n") {
+		switch {
+		case strings.TrimSpace(line) == begin:
+			inBlock = true
+			removed = true
+		case strings.TrimSpace(line) == end:
+			inBlock = false
+		case inBlock:
+			// 片段内容连同围栏标记一起丢弃
+		default:
+			out = append(out, line)
+		}
+	}
+	if !removed {
+		return nil
+	}
+
+	return withCrontabLock(func() error { return writeCrontabRaw(strings.Join(out, "\n") + "\n") })
+}
+
+// handleSubscriptionsAPI 处理 GET（列出）和 POST（新增）/api/subscriptions。
+func handleSubscriptionsAPI(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		subscriptions.mu.Lock()
+		list := make([]*Subscription, 0, len(subscriptions.subs))
+		for _, s := range subscriptions.subs {
+			list = append(list, s)
+		}
+		subscriptions.mu.Unlock()
+		json.NewEncoder(w).Encode(list)
+	case "POST":
+		var sub Subscription
+		if err := json.NewDecoder(r.Body).Decode(&sub); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to parse request body: %v", err), http.StatusBadRequest)
+			return
+		}
+		if sub.Alias == "" || sub.URL == "" {
+			http.Error(w, "alias and url are required", http.StatusBadRequest)
+			return
+		}
+		if sub.Branch == "" {
+			sub.Branch = "main"
+		}
+		// RefreshCron 如果留空或者不合法，Minute()/Hour()/... 会各自退化成 "*"，
+		// 导致 run() 里的 schedule.matches 每分钟都命中，变成无限频率地
+		// clone/pull 远程仓库。留空时给一个稳妥的默认值，格式错误则直接拒绝。
+		if strings.TrimSpace(sub.RefreshCron) == "" {
+			sub.RefreshCron = "0 * * * *" // 默认每小时同步一次
+		} else if len(sub.fields()) != 5 {
+			http.Error(w, "refreshCron must be a standard 5-field cron expression", http.StatusBadRequest)
+			return
+		} else if _, err := parseCronSchedule(sub.Minute(), sub.Hour(), sub.DayOfMonthField(), sub.MonthField(), sub.DayOfWeekField()); err != nil {
+			http.Error(w, fmt.Sprintf("invalid refreshCron: %v", err), http.StatusBadRequest)
+			return
+		}
+		subscriptions.mu.Lock()
+		subscriptions.subs[sub.Alias] = &sub
+		err := subscriptions.saveLocked()
+		subscriptions.mu.Unlock()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to persist subscription: %v", err), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(sub)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleSubscriptionItemAPI 处理 /api/subscriptions/{alias} 及 /api/subscriptions/{alias}/sync。
+func handleSubscriptionItemAPI(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/subscriptions/")
+	parts := strings.Split(strings.Trim(rest, "/"), "/")
+	if len(parts) == 0 || parts[0] == "" {
+		http.Error(w, "alias is required", http.StatusBadRequest)
+		return
+	}
+	alias := parts[0]
+
+	if len(parts) == 2 && parts[1] == "sync" {
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := subscriptions.sync(alias); err != nil {
+			http.Error(w, fmt.Sprintf("Sync failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		subscriptions.mu.Lock()
+		sub := subscriptions.subs[alias]
+		subscriptions.mu.Unlock()
+		json.NewEncoder(w).Encode(sub)
+		return
+	}
+
+	if r.Method != "DELETE" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := removeSubscriptionBlock(alias); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to remove subscription's crontab block: %v", err), http.StatusInternalServerError)
+		return
+	}
+	subscriptions.mu.Lock()
+	delete(subscriptions.subs, alias)
+	err := subscriptions.saveLocked()
+	subscriptions.mu.Unlock()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to persist subscription removal: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}